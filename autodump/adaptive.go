@@ -0,0 +1,170 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import "math"
+
+// AdaptiveParams holds the hill-climbing knobs shared by the CPU, memory
+// and goroutine anomaly detectors: the threshold is
+// (baseline + k*stddev) * ratio, where ratio starts at 1.25 and is nudged
+// by epsilon each time a dump is scored, up after a false alarm and down
+// after a real spike. Windows without a dump leave ratio unchanged.
+// Override via WithAdaptive; sane defaults are used otherwise.
+type AdaptiveParams struct {
+	K          float64 // threshold = (baseline + k*stddev) * ratio
+	Epsilon    float64 // ratio step applied each time a dump is scored
+	WindowSize int     // samples used to compute the rolling baseline/stddev
+	MinRatio   float64 // threshold floor, as a ratio over baseline
+	MaxRatio   float64 // threshold ceiling, as a ratio over baseline
+}
+
+func defaultAdaptiveParams() AdaptiveParams {
+	return AdaptiveParams{
+		K:          2,
+		Epsilon:    0.05,
+		WindowSize: 10,
+		MinRatio:   1.05,
+		MaxRatio:   3,
+	}
+}
+
+// WithAdaptive overrides the hill-climbing knobs used by all three
+// triggers: k controls how many standard deviations above baseline the
+// threshold starts at, epsilon is the ratio step applied each time a dump
+// is scored, windowSize is
+// the number of samples the baseline/stddev are computed over, and
+// minRatio/maxRatio clamp the threshold to [baseline*minRatio,
+// baseline*maxRatio].
+func WithAdaptive(k, epsilon float64, windowSize int, minRatio, maxRatio float64) Option {
+	return func(d *Dumper) {
+		d.adaptiveParams = AdaptiveParams{
+			K:          k,
+			Epsilon:    epsilon,
+			WindowSize: windowSize,
+			MinRatio:   minRatio,
+			MaxRatio:   maxRatio,
+		}
+	}
+}
+
+// climbState is the per-profile hill-climbing state. It tracks a
+// threshold ratio (over baseline + k*stddev) that is nudged up when the
+// previous dump turns out to have been a false alarm, and back down when
+// it turns out to have caught a real spike.
+type climbState struct {
+	ratio     float64 // current threshold, as a ratio over baseline + k*stddev
+	direction float64 // +epsilon climbing up, -epsilon climbing down
+
+	pendingEval  bool    // true while waiting to score the last dump
+	evalBaseline float64 // baseline at the time the last dump fired
+}
+
+func newClimbState() *climbState {
+	return &climbState{ratio: 1.25}
+}
+
+// check scores any dump pending evaluation against latest, then decides
+// whether latest is itself anomalous against window. window is expected
+// to have latest as its last element (the caller appends before calling);
+// the baseline/stddev are computed over the window *excluding* latest, so
+// a sample is never scored against a baseline it has already skewed. It
+// returns true when a new dump should fire.
+func (c *climbState) check(params AdaptiveParams, window []int, latest int) bool {
+	if c.direction == 0 {
+		c.direction = params.Epsilon
+	}
+
+	priorWindow := window
+	if n := len(window); n > 0 {
+		priorWindow = window[:n-1]
+	}
+
+	if c.pendingEval {
+		// a real spike keeps the metric elevated after the dump; a false
+		// alarm sees it fall back toward (or below) the old baseline.
+		wasFalseAlarm := float64(latest) < c.evalBaseline*params.MinRatio
+		c.climb(params, wasFalseAlarm)
+		c.pendingEval = false
+
+		if !wasFalseAlarm {
+			// the incident that triggered the last dump is still ongoing;
+			// don't fire again on it until it resolves and a fresh spike
+			// is seen above the (now climbed) threshold.
+			return false
+		}
+	}
+
+	if len(priorWindow) == 0 {
+		return false
+	}
+
+	baseline, stddev := meanStdDev(priorWindow)
+	threshold := (baseline + params.K*stddev) * c.ratio
+
+	if floor := baseline * params.MinRatio; threshold < floor {
+		threshold = floor
+	}
+	if ceil := baseline * params.MaxRatio; threshold > ceil {
+		threshold = ceil
+	}
+
+	if float64(latest) <= threshold {
+		return false
+	}
+
+	c.pendingEval = true
+	c.evalBaseline = baseline
+	return true
+}
+
+// climb nudges the threshold ratio after a dump is scored. Repeated
+// outcomes of the same kind keep climbing in the same direction; a
+// flipped outcome reverses it, which is the classic hill-climbing
+// accept/reverse step used by brpc's auto-concurrency limiter.
+func (c *climbState) climb(params AdaptiveParams, wasFalseAlarm bool) {
+	if wasFalseAlarm {
+		c.direction = math.Abs(params.Epsilon)
+	} else {
+		c.direction = -math.Abs(params.Epsilon)
+	}
+
+	c.ratio += c.direction
+	if c.ratio < 1 {
+		c.ratio = 1
+	}
+}
+
+func meanStdDev(samples []int) (mean, stddev float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+
+	sum := 0
+	for _, s := range samples {
+		sum += s
+	}
+	mean = float64(sum) / float64(len(samples))
+
+	var variance float64
+	for _, s := range samples {
+		d := float64(s) - mean
+		variance += d * d
+	}
+	variance /= float64(len(samples))
+
+	return mean, math.Sqrt(variance)
+}
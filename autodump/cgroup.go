@@ -0,0 +1,222 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Cgroup file paths. These are vars rather than consts so tests can point
+// them at a fixture tree (and call detectCgroupVersion to re-detect
+// against it) instead of requiring a real /sys/fs/cgroup.
+var (
+	cgroupV1MemLimitPath  = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
+	cgroupV1CPUUsagePath  = "/sys/fs/cgroup/cpuacct/cpuacct.usage"
+	cgroupV1CPUQuotaPath  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupV1CPUPeriodPath = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+
+	cgroupV2MemLimitPath = "/sys/fs/cgroup/memory.max"
+	cgroupV2CPUStatPath  = "/sys/fs/cgroup/cpu.stat"
+	cgroupV2CPUMaxPath   = "/sys/fs/cgroup/cpu.max"
+)
+
+// memLimitUnbounded is what cgroup v1 reports when there is no limit
+// configured; it is close to the max int64 rounded down to a page size.
+const memLimitUnbounded = 9223372036854771712
+
+// cgroupVersion enumerates which cgroup hierarchy (if any) the current
+// process is confined by.
+type cgroupVersion int
+
+const (
+	cgroupNone cgroupVersion = iota
+	cgroupV1
+	cgroupV2
+)
+
+var detectedCgroup = detectCgroupVersion()
+
+func detectCgroupVersion() cgroupVersion {
+	if _, err := os.Stat(cgroupV2CPUStatPath); err == nil {
+		return cgroupV2
+	}
+	if _, err := os.Stat(cgroupV1CPUUsagePath); err == nil {
+		return cgroupV1
+	}
+	return cgroupNone
+}
+
+// IsCgroup reports whether the running process is confined by a cgroup
+// (v1 or v2), which is the common case inside a Kubernetes pod or Docker
+// container.
+func IsCgroup() bool {
+	return detectedCgroup != cgroupNone
+}
+
+// GetCgroupMemoryLimit returns the memory limit (in bytes) imposed on the
+// current cgroup. It returns ok == false when there is no cgroup, or the
+// cgroup has no limit configured (e.g. "max" on v2, or the v1 sentinel
+// value).
+func GetCgroupMemoryLimit() (limit uint64, ok bool) {
+	var raw string
+	switch detectedCgroup {
+	case cgroupV1:
+		raw = readFirstLine(cgroupV1MemLimitPath)
+	case cgroupV2:
+		raw = readFirstLine(cgroupV2MemLimitPath)
+	default:
+		return 0, false
+	}
+
+	if raw == "" || raw == "max" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || n >= memLimitUnbounded {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// cgroupCPUQuota returns the number of CPUs (fractional) made available to
+// the cgroup by its CFS quota/period, e.g. 1.5 for "quota=150000
+// period=100000". ok is false when no quota is set (unlimited).
+func cgroupCPUQuota() (cpus float64, ok bool) {
+	switch detectedCgroup {
+	case cgroupV1:
+		quota, err1 := strconv.ParseInt(readFirstLine(cgroupV1CPUQuotaPath), 10, 64)
+		period, err2 := strconv.ParseInt(readFirstLine(cgroupV1CPUPeriodPath), 10, 64)
+		if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return float64(quota) / float64(period), true
+	case cgroupV2:
+		fields := strings.Fields(readFirstLine(cgroupV2CPUMaxPath))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		quota, err1 := strconv.ParseInt(fields[0], 10, 64)
+		period, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return float64(quota) / float64(period), true
+	default:
+		return 0, false
+	}
+}
+
+// cgroupCPUUsageNanos returns the cumulative CPU time (in nanoseconds)
+// consumed by the cgroup so far.
+func cgroupCPUUsageNanos() (nanos uint64, ok bool) {
+	switch detectedCgroup {
+	case cgroupV1:
+		n, err := strconv.ParseUint(readFirstLine(cgroupV1CPUUsagePath), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	case cgroupV2:
+		for _, line := range readLines(cgroupV2CPUStatPath) {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, err := strconv.ParseUint(fields[1], 10, 64)
+				if err != nil {
+					return 0, false
+				}
+				return usec * 1000, true
+			}
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// cgroupCPUSampler tracks successive cgroup CPU usage readings so callers
+// can derive a CPU usage percentage from the delta between two samples,
+// analogous to what gopsutil does against host-wide totals.
+type cgroupCPUSampler struct {
+	lastUsageNanos uint64
+	lastSampleTime int64 // unix nanos
+	initialized    bool
+}
+
+// sample returns the cgroup CPU usage percentage (0-100, relative to the
+// cgroup's CPU quota) observed since the previous call. The first call
+// always returns 0 because there is no prior reading to diff against.
+func (s *cgroupCPUSampler) sample(nowUnixNano int64) (percent float64, ok bool) {
+	usage, ok := cgroupCPUUsageNanos()
+	if !ok {
+		return 0, false
+	}
+
+	quota, hasQuota := cgroupCPUQuota()
+	if !hasQuota {
+		quota = float64(runtime.NumCPU())
+	}
+
+	defer func() {
+		s.lastUsageNanos = usage
+		s.lastSampleTime = nowUnixNano
+		s.initialized = true
+	}()
+
+	if !s.initialized {
+		return 0, true
+	}
+
+	elapsed := nowUnixNano - s.lastSampleTime
+	if elapsed <= 0 {
+		return 0, true
+	}
+
+	// usage can go backward if the cgroup counter was reset (e.g. the
+	// cgroup was recreated under the same path); treat that as no usage
+	// rather than underflowing the unsigned subtraction.
+	var usedNanos uint64
+	if usage > s.lastUsageNanos {
+		usedNanos = usage - s.lastUsageNanos
+	}
+	capacityNanos := quota * float64(elapsed)
+	if capacityNanos <= 0 {
+		return 0, true
+	}
+
+	return float64(usedNanos) / capacityNanos * 100, true
+}
+
+func readFirstLine(path string) string {
+	lines := readLines(path)
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[0]
+}
+
+func readLines(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
@@ -0,0 +1,242 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withCgroupFixture points the cgroup path vars at files under a fresh
+// t.TempDir(), writes the given fixture files into it, and forces
+// detectedCgroup to version for the duration of the test.
+func withCgroupFixture(t *testing.T, version cgroupVersion, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	origPaths := [...]*string{
+		&cgroupV1MemLimitPath, &cgroupV1CPUUsagePath, &cgroupV1CPUQuotaPath, &cgroupV1CPUPeriodPath,
+		&cgroupV2MemLimitPath, &cgroupV2CPUStatPath, &cgroupV2CPUMaxPath,
+	}
+	origValues := make([]string, len(origPaths))
+	for i, p := range origPaths {
+		origValues[i] = *p
+	}
+	origDetected := detectedCgroup
+
+	switch version {
+	case cgroupV1:
+		cgroupV1MemLimitPath = filepath.Join(dir, "memory.limit_in_bytes")
+		cgroupV1CPUUsagePath = filepath.Join(dir, "cpuacct.usage")
+		cgroupV1CPUQuotaPath = filepath.Join(dir, "cpu.cfs_quota_us")
+		cgroupV1CPUPeriodPath = filepath.Join(dir, "cpu.cfs_period_us")
+	case cgroupV2:
+		cgroupV2MemLimitPath = filepath.Join(dir, "memory.max")
+		cgroupV2CPUStatPath = filepath.Join(dir, "cpu.stat")
+		cgroupV2CPUMaxPath = filepath.Join(dir, "cpu.max")
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("writing fixture %s: %v", name, err)
+		}
+	}
+
+	detectedCgroup = version
+
+	t.Cleanup(func() {
+		for i, p := range origPaths {
+			*p = origValues[i]
+		}
+		detectedCgroup = origDetected
+	})
+}
+
+func TestGetCgroupMemoryLimit(t *testing.T) {
+	cases := []struct {
+		name      string
+		version   cgroupVersion
+		files     map[string]string
+		wantLimit uint64
+		wantOK    bool
+	}{
+		{
+			name:      "v1 bounded limit",
+			version:   cgroupV1,
+			files:     map[string]string{"memory.limit_in_bytes": "536870912\n"},
+			wantLimit: 536870912,
+			wantOK:    true,
+		},
+		{
+			name:    "v1 unbounded sentinel",
+			version: cgroupV1,
+			files:   map[string]string{"memory.limit_in_bytes": "9223372036854771712\n"},
+			wantOK:  false,
+		},
+		{
+			name:      "v2 bounded limit",
+			version:   cgroupV2,
+			files:     map[string]string{"memory.max": "268435456\n"},
+			wantLimit: 268435456,
+			wantOK:    true,
+		},
+		{
+			name:    "v2 max means unbounded",
+			version: cgroupV2,
+			files:   map[string]string{"memory.max": "max\n"},
+			wantOK:  false,
+		},
+		{
+			name:    "no cgroup",
+			version: cgroupNone,
+			wantOK:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withCgroupFixture(t, c.version, c.files)
+
+			limit, ok := GetCgroupMemoryLimit()
+			if ok != c.wantOK || (ok && limit != c.wantLimit) {
+				t.Fatalf("GetCgroupMemoryLimit() = (%v, %v), want (%v, %v)", limit, ok, c.wantLimit, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestCgroupCPUQuota(t *testing.T) {
+	cases := []struct {
+		name     string
+		version  cgroupVersion
+		files    map[string]string
+		wantCPUs float64
+		wantOK   bool
+	}{
+		{
+			name:     "v1 one and a half cpus",
+			version:  cgroupV1,
+			files:    map[string]string{"cpu.cfs_quota_us": "150000\n", "cpu.cfs_period_us": "100000\n"},
+			wantCPUs: 1.5,
+			wantOK:   true,
+		},
+		{
+			name:    "v1 unlimited quota",
+			version: cgroupV1,
+			files:   map[string]string{"cpu.cfs_quota_us": "-1\n", "cpu.cfs_period_us": "100000\n"},
+			wantOK:  false,
+		},
+		{
+			name:     "v2 one and a half cpus",
+			version:  cgroupV2,
+			files:    map[string]string{"cpu.max": "150000 100000\n"},
+			wantCPUs: 1.5,
+			wantOK:   true,
+		},
+		{
+			name:    "v2 max means unlimited",
+			version: cgroupV2,
+			files:   map[string]string{"cpu.max": "max 100000\n"},
+			wantOK:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withCgroupFixture(t, c.version, c.files)
+
+			cpus, ok := cgroupCPUQuota()
+			if ok != c.wantOK || (ok && cpus != c.wantCPUs) {
+				t.Fatalf("cgroupCPUQuota() = (%v, %v), want (%v, %v)", cpus, ok, c.wantCPUs, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestCgroupCPUUsageNanos(t *testing.T) {
+	cases := []struct {
+		name      string
+		version   cgroupVersion
+		files     map[string]string
+		wantNanos uint64
+		wantOK    bool
+	}{
+		{
+			name:      "v1 usage",
+			version:   cgroupV1,
+			files:     map[string]string{"cpuacct.usage": "123456789\n"},
+			wantNanos: 123456789,
+			wantOK:    true,
+		},
+		{
+			name:      "v2 usage_usec converted to nanos",
+			version:   cgroupV2,
+			files:     map[string]string{"cpu.stat": "usage_usec 1000\nuser_usec 700\nsystem_usec 300\n"},
+			wantNanos: 1000000,
+			wantOK:    true,
+		},
+		{
+			name:    "v2 missing usage_usec",
+			version: cgroupV2,
+			files:   map[string]string{"cpu.stat": "user_usec 700\n"},
+			wantOK:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withCgroupFixture(t, c.version, c.files)
+
+			nanos, ok := cgroupCPUUsageNanos()
+			if ok != c.wantOK || (ok && nanos != c.wantNanos) {
+				t.Fatalf("cgroupCPUUsageNanos() = (%v, %v), want (%v, %v)", nanos, ok, c.wantNanos, c.wantOK)
+			}
+		})
+	}
+}
+
+// TestCgroupCPUSamplerSample exercises the first-call/second-call contract:
+// no prior reading yields 0 with ok==true, and a subsequent call derives a
+// percentage from the usage delta against the quota.
+func TestCgroupCPUSamplerSample(t *testing.T) {
+	withCgroupFixture(t, cgroupV1, map[string]string{
+		"cpuacct.usage":     "0",
+		"cpu.cfs_quota_us":  "100000",
+		"cpu.cfs_period_us": "100000",
+	})
+
+	s := &cgroupCPUSampler{}
+
+	percent, ok := s.sample(0)
+	if !ok || percent != 0 {
+		t.Fatalf("first sample() = (%v, %v), want (0, true)", percent, ok)
+	}
+
+	if err := os.WriteFile(cgroupV1CPUUsagePath, []byte("500000000"), 0644); err != nil {
+		t.Fatalf("updating usage fixture: %v", err)
+	}
+
+	percent, ok = s.sample(int64(time.Second))
+	if !ok {
+		t.Fatalf("second sample() ok = false, want true")
+	}
+	if percent != 50 {
+		t.Fatalf("second sample() = %v%%, want 50%% (500ms used of 1 cpu over 1s)", percent)
+	}
+}
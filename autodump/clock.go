@@ -0,0 +1,54 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import "time"
+
+// Clock abstracts time so tests can drive the dump loop's ticks, and any
+// background work a trigger schedules after itself (e.g. stopping a CPU
+// profile once it's been sampling long enough), deterministically instead
+// of waiting on a real timer.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is the subset of time.Ticker that Clock implementations need to
+// provide.
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// realClock is the default Clock, backed by the standard time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{t: time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r *realTicker) Chan() <-chan time.Time { return r.t.C }
+func (r *realTicker) Stop()                  { r.t.Stop() }
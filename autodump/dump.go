@@ -14,195 +14,460 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
+
+// Package autodump watches process CPU, memory and goroutine count (and,
+// optionally, lock contention and scheduler activity) and automatically
+// captures pprof profiles when they look anomalous, so you have a dump
+// from the moment of an incident instead of having to reproduce it.
 package autodump
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"os"
-	"path"
-	"runtime"
-	"runtime/pprof"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var (
-	dumpPath                 = "./"
-	maxDumpTimesPerDay       = 10
-	cpuDumpingFlag     int64 = 0
-)
-
-func init() {
-	Init("", 5, 0)
+// Option configures a Dumper, e.g. WithSink, WithRetention, WithAdaptive,
+// WithMemTrigger, WithBlockProfiling, WithMutexProfiling or
+// WithTraceDuration.
+type Option func(*Dumper)
+
+// cpuProfileDuration is how long a triggered CPU profile samples before
+// it's stopped and written out.
+const cpuProfileDuration = 10 * time.Second
+
+// Config is the input to New.
+type Config struct {
+	// Path is the directory dumps are written under when using the
+	// default filesystem sink. Defaults to "./".
+	Path string
+	// TickInterval is how often the dump loop samples and evaluates
+	// triggers. Defaults to 5s.
+	TickInterval time.Duration
+	// MaxDumpTimesPerDay limits dumps (across all kinds) in a calendar
+	// day: once more than this many have fired, the loop skips its ticks
+	// until the day changes. One tick can fire several kinds, so the
+	// day's total can exceed this number. Defaults to 10.
+	MaxDumpTimesPerDay int
+
+	// Sink overrides the default filesystem sink.
+	Sink DumpSink
+	// Logger overrides the default standard-log-backed logger.
+	Logger Logger
+	// Clock overrides the default wall-clock/time.Ticker implementation;
+	// tests inject a fake one to drive the loop deterministically.
+	Clock Clock
+	// Profiler overrides the default runtime/gopsutil-backed profiler;
+	// tests inject a fake one to fabricate CPU/mem/goroutine sequences.
+	Profiler Profiler
+
+	Opts []Option
 }
 
-// Init the dumper, set dump path and dump tick interval
-func Init(path string, interval int, maxDumpTimes int) {
-	if len(path) > 0 {
-		dumpPath = path
+// Dumper owns one dump loop: its thresholds, rolling stats, and the
+// sink/logger/clock/profiler it samples and writes through. The
+// package-level functions (Init, WithSink, MemStatsHistory, Handler, ...)
+// are a thin wrapper around a default Dumper for callers that don't need
+// to embed or test their own instance.
+type Dumper struct {
+	dumpPath       string
+	cpuDumpingFlag int64
+	dumpSeq        int64
+	tickInterval   time.Duration
+
+	// mu guards maxDumpTimesPerDay, threshold and stats: the loop goroutine
+	// mutates them every tick while the HTTP control endpoints
+	// (statusHandler, configHandler) read and write them from whatever
+	// goroutine is serving the request.
+	mu                 sync.RWMutex
+	maxDumpTimesPerDay int
+
+	threshold struct {
+		cpuDumpInterval       time.Duration
+		memDumpInterval       time.Duration
+		goroutineDumpInterval time.Duration
+		blockDumpInterval     time.Duration
+		mutexDumpInterval     time.Duration
+		traceDumpInterval     time.Duration
 	}
 
-	if maxDumpTimes > 0 {
-		maxDumpTimesPerDay = maxDumpTimes
+	stats struct {
+		latestCPUDumpTime time.Time
+		latestCPUUsage    int
+		cpuUsageLRU       []int
+		cpuClimb          *climbState
+
+		latestMemDumpTime   time.Time
+		latestMemUsage      int
+		memUsageLRU         []int
+		memClimb            *climbState
+		memStatsHistory     []memStatsSnapshot
+		latestMemDumpReason string
+
+		latestGoroutineDumpTime time.Time
+		latestGoroutineNum      int
+		goroutineNumLRU         []int
+		goroutineClimb          *climbState
+
+		latestBlockDumpTime time.Time
+		blockCountLRU       []int
+		blockClimb          *climbState
+
+		latestMutexDumpTime time.Time
+		mutexCountLRU       []int
+		mutexClimb          *climbState
+
+		latestTraceDumpTime time.Time
 	}
 
-	go startDumpLoop()
-}
+	adaptiveParams   AdaptiveParams
+	retention        Retention
+	gcPressureParams GCPressureParams
+	profilingCfg     profilingConfig
 
-var threshold struct {
-	cpuDumpInterval       time.Duration
-	memDumpInterval       time.Duration
-	goroutineDumpInterval time.Duration
+	sink     DumpSink
+	logger   Logger
+	clock    Clock
+	profiler Profiler
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-var stats struct {
-	latestCPUDumpTime time.Time
-	latestCPUUsage    int
-	cpuUsageLRU       []int
+// New builds a Dumper from cfg but does not start its loop; call Start to
+// do that.
+func New(cfg Config) (*Dumper, error) {
+	d := &Dumper{
+		dumpPath:           "./",
+		maxDumpTimesPerDay: 10,
+		tickInterval:       5 * time.Second,
+		logger:             stdLogger{},
+		clock:              realClock{},
+		profiler:           newRealProfiler(),
+		adaptiveParams:     defaultAdaptiveParams(),
+		retention:          defaultRetention(),
+		gcPressureParams:   defaultGCPressureParams(),
+		profilingCfg:       defaultProfilingConfig(),
+	}
+	d.threshold.cpuDumpInterval = 30 * time.Second
+	d.threshold.memDumpInterval = 30 * time.Second
+	d.threshold.goroutineDumpInterval = 30 * time.Second
+	d.threshold.blockDumpInterval = 30 * time.Second
+	d.threshold.mutexDumpInterval = 30 * time.Second
+	d.threshold.traceDumpInterval = 30 * time.Second
+
+	if cfg.Path != "" {
+		d.dumpPath = cfg.Path
+	}
+	if cfg.TickInterval > 0 {
+		d.tickInterval = cfg.TickInterval
+	}
+	if cfg.MaxDumpTimesPerDay > 0 {
+		d.maxDumpTimesPerDay = cfg.MaxDumpTimesPerDay
+	}
+	if cfg.Logger != nil {
+		d.logger = cfg.Logger
+	}
+	if cfg.Clock != nil {
+		d.clock = cfg.Clock
+	}
+	if cfg.Profiler != nil {
+		d.profiler = cfg.Profiler
+	}
+
+	if cfg.Sink != nil {
+		d.sink = cfg.Sink
+	} else {
+		d.sink = newFSSink(d)
+	}
 
-	latestMemDumpTime time.Time
-	latestMemUsage    int
-	memUsageLRU       []int
+	for _, opt := range cfg.Opts {
+		opt(d)
+	}
 
-	latestGoroutineDumpTime time.Time
-	latestGoroutineNum      int
-	goroutineNumLRU         []int
+	return d, nil
 }
 
-func startDumpLoop() {
-	lastDumpTime := time.Now()
-	dumpTimes := 0
-	for range time.Tick(time.Second * 5) {
-		if atomic.LoadInt64(&cpuDumpingFlag) == 1 {
-			// is cpu dumping now, skip this cycle
-			continue
-		}
+// Start runs the dump loop in a background goroutine until ctx is
+// cancelled or Stop is called.
+func (d *Dumper) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	go d.loop(ctx)
+}
 
-		// last trigger time is the same day
-		if time.Now().Format("20060102") != lastDumpTime.Format("20060102") {
-			dumpTimes = 0
-		} else if dumpTimes > maxDumpTimesPerDay {
-			continue
-		}
+// Stop cancels the dump loop, waits for it to exit, and resets the
+// block/mutex sampling rates it may have enabled.
+func (d *Dumper) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	if d.done != nil {
+		<-d.done
+	}
+	d.resetProfilingRates()
+}
 
-		if triggered := memProfile(); triggered {
-			dumpTimes++
-			lastDumpTime = time.Now()
-			println("dump mem")
-		}
+func (d *Dumper) loop(ctx context.Context) {
+	defer close(d.done)
 
-		if triggered := cpuProfile(); triggered {
-			dumpTimes++
-			lastDumpTime = time.Now()
-			println("dump cpu")
-		}
+	ticker := d.clock.NewTicker(d.tickInterval)
+	defer ticker.Stop()
+
+	lastDumpTime := d.clock.Now()
+	dumpTimes := 0
 
-		if triggered := goroutineProfile(); triggered {
-			dumpTimes++
-			lastDumpTime = time.Now()
-			println("dump g")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			if atomic.LoadInt64(&d.cpuDumpingFlag) == 1 {
+				// is cpu dumping now, skip this cycle
+				continue
+			}
+
+			d.mu.RLock()
+			maxDumpTimesPerDay := d.maxDumpTimesPerDay
+			d.mu.RUnlock()
+
+			// last trigger time is the same day
+			if d.clock.Now().Format("20060102") != lastDumpTime.Format("20060102") {
+				dumpTimes = 0
+			} else if dumpTimes > maxDumpTimesPerDay {
+				continue
+			}
+
+			if d.memProfile() {
+				dumpTimes++
+				lastDumpTime = d.clock.Now()
+				d.logger.Println("dump mem")
+			}
+
+			if d.cpuProfile() {
+				dumpTimes++
+				lastDumpTime = d.clock.Now()
+				d.logger.Println("dump cpu")
+			}
+
+			if d.goroutineProfile() {
+				dumpTimes++
+				lastDumpTime = d.clock.Now()
+				d.logger.Println("dump g")
+			}
+
+			if d.profilingCfg.blockEnabled && d.blockProfile() {
+				dumpTimes++
+				lastDumpTime = d.clock.Now()
+				d.logger.Println("dump block")
+			}
+
+			if d.profilingCfg.mutexEnabled && d.mutexProfile() {
+				dumpTimes++
+				lastDumpTime = d.clock.Now()
+				d.logger.Println("dump mutex")
+			}
 		}
 	}
 }
 
-func trim1elemIfMoreThan10(arr []int) []int {
-	if len(arr) <= 10 {
+// trimToWindow keeps only the most recent windowSize elements of arr,
+// matching AdaptiveParams.WindowSize. A non-positive windowSize leaves
+// arr untouched.
+func trimToWindow(arr []int, windowSize int) []int {
+	if windowSize <= 0 || len(arr) <= windowSize {
 		return arr
 	}
 
-	return arr[1:]
+	return arr[len(arr)-windowSize:]
 }
 
-func memProfile() bool {
-	curMemUsage := 0
-	stats.memUsageLRU = append(stats.memUsageLRU, curMemUsage)
-	stats.memUsageLRU = trim1elemIfMoreThan10(stats.memUsageLRU)
+// dumpName builds a collision-proof name for a dump of the given kind:
+// the second-granularity timestamp stays for readability, suffixed with
+// a per-Dumper monotonic sequence number so that two dumps of the same
+// kind started within the same second (e.g. rapid /autodump/trigger
+// calls) never overwrite each other.
+func (d *Dumper) dumpName(kind string) string {
+	seq := atomic.AddInt64(&d.dumpSeq, 1)
+	return fmt.Sprintf("%s.dump_%v.%d", kind, d.clock.Now().Format("20060102150405"), seq)
+}
 
-	if time.Since(stats.latestMemDumpTime) < threshold.memDumpInterval {
+func (d *Dumper) memProfile() bool {
+	curMemUsage, err := d.profiler.MemUsagePercent()
+	if err != nil {
+		d.logger.Println(err)
 		return false
 	}
+	d.recordMemStatsSnapshot(d.profiler.ReadMemStats())
+	limitBytes, _ := memLimitBytes()
 
-	mStats, _ := getMemProfileStats()
-	fmt.Println("memory stats", mStats)
+	d.mu.Lock()
+	d.stats.latestMemUsage = int(curMemUsage)
+	d.stats.memUsageLRU = append(d.stats.memUsageLRU, int(curMemUsage))
+	d.stats.memUsageLRU = trimToWindow(d.stats.memUsageLRU, d.adaptiveParams.WindowSize)
 
-	fileName := fmt.Sprintf("%v.dump_v%v", path.Join(dumpPath, "heap"), time.Now().Format("20060102150405"))
-	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		fmt.Println(err)
+	if d.clock.Now().Sub(d.stats.latestMemDumpTime) < d.threshold.memDumpInterval {
+		d.mu.Unlock()
 		return false
 	}
-	defer f.Close()
 
-	pprof.Lookup("heap").WriteTo(f, 1)
+	if d.stats.memClimb == nil {
+		d.stats.memClimb = newClimbState()
+	}
+	anomalous := d.stats.memClimb.check(d.adaptiveParams, d.stats.memUsageLRU, int(curMemUsage))
+	reason := gcPressureReason(d.gcPressureParams, d.stats.memStatsHistory, limitBytes)
+
+	if !anomalous && reason == "" {
+		d.mu.Unlock()
+		return false
+	}
+	if reason == "" {
+		reason = "rss_anomaly"
+	}
+	d.stats.latestMemDumpReason = reason
+	d.mu.Unlock()
+
+	mStats, _ := d.profiler.MemProfileStats()
+	d.logger.Println("memory stats", mStats, "reason", reason)
+
+	var buf bytes.Buffer
+	if prof := d.profiler.Lookup("heap"); prof != nil {
+		prof.WriteTo(&buf, 1)
+	}
+
+	name := d.dumpName("heap")
+	if err := d.sink.Write(context.Background(), "heap", name, &buf); err != nil {
+		d.logger.Println(err)
+		return false
+	}
+
+	d.mu.Lock()
+	d.stats.latestMemDumpTime = d.clock.Now()
+	d.mu.Unlock()
 	return true
 }
 
-func cpuProfile() bool {
-	curCPUUsage, err := getCPUUsage()
+func (d *Dumper) cpuProfile() bool {
+	curCPUUsage, err := d.profiler.CPUUsagePercent()
 	if err != nil {
-		// log error
+		d.logger.Println(err)
 		return false
 	}
 
-	stats.cpuUsageLRU = append(stats.cpuUsageLRU, int(curCPUUsage))
-	stats.cpuUsageLRU = trim1elemIfMoreThan10(stats.cpuUsageLRU)
+	d.mu.Lock()
+	d.stats.latestCPUUsage = int(curCPUUsage)
+	d.stats.cpuUsageLRU = append(d.stats.cpuUsageLRU, int(curCPUUsage))
+	d.stats.cpuUsageLRU = trimToWindow(d.stats.cpuUsageLRU, d.adaptiveParams.WindowSize)
 
-	if time.Since(stats.latestCPUDumpTime) < threshold.cpuDumpInterval {
+	if d.clock.Now().Sub(d.stats.latestCPUDumpTime) < d.threshold.cpuDumpInterval {
+		d.mu.Unlock()
 		return false
 	}
 
-	// TODO, if the cpu usage matches the rule
-	fileName := fmt.Sprintf("%v.dump_%v", path.Join(dumpPath, "cpu"), time.Now().Format("20060102150405"))
-	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
+	if d.stats.cpuClimb == nil {
+		d.stats.cpuClimb = newClimbState()
+	}
+	anomalous := d.stats.cpuClimb.check(d.adaptiveParams, d.stats.cpuUsageLRU, int(curCPUUsage))
+	d.mu.Unlock()
+	if !anomalous {
 		return false
 	}
-	defer f.Close()
 
-	err = pprof.StartCPUProfile(f)
-	if err != nil {
+	name := d.dumpName("cpu")
+
+	var buf bytes.Buffer
+	if err := d.profiler.StartCPUProfile(&buf); err != nil {
 		return false
 	}
-	atomic.StoreInt64(&cpuDumpingFlag, 1)
-	time.AfterFunc(time.Second*10, func() {
-		pprof.StopCPUProfile()
-		atomic.StoreInt64(&cpuDumpingFlag, 0)
-	})
+	atomic.StoreInt64(&d.cpuDumpingFlag, 1)
+
+	d.mu.Lock()
+	d.stats.latestCPUDumpTime = d.clock.Now()
+	d.mu.Unlock()
+
+	go func() {
+		<-d.clock.After(cpuProfileDuration)
+		d.profiler.StopCPUProfile()
+		atomic.StoreInt64(&d.cpuDumpingFlag, 0)
+
+		if err := d.sink.Write(context.Background(), "cpu", name, &buf); err != nil {
+			d.logger.Println(err)
+		}
+	}()
 
 	return true
 }
 
-func goroutineProfile() bool {
-	goroutineNum := runtime.NumGoroutine()
-	stats.goroutineNumLRU = append(stats.goroutineNumLRU, goroutineNum)
-	// trim to len == 10
-	if len(stats.goroutineNumLRU) > 10 {
-		stats.goroutineNumLRU = stats.goroutineNumLRU[1:]
-	}
+func (d *Dumper) goroutineProfile() bool {
+	goroutineNum := d.profiler.NumGoroutine()
 
-	if time.Since(stats.latestGoroutineDumpTime) < threshold.goroutineDumpInterval {
+	d.mu.Lock()
+	d.stats.latestGoroutineNum = goroutineNum
+	d.stats.goroutineNumLRU = append(d.stats.goroutineNumLRU, goroutineNum)
+	d.stats.goroutineNumLRU = trimToWindow(d.stats.goroutineNumLRU, d.adaptiveParams.WindowSize)
+
+	if d.clock.Now().Sub(d.stats.latestGoroutineDumpTime) < d.threshold.goroutineDumpInterval {
+		d.mu.Unlock()
 		return false
 	}
 
-	sum := 0
-	for _, n := range stats.goroutineNumLRU {
-		sum += n
+	if d.stats.goroutineClimb == nil {
+		d.stats.goroutineClimb = newClimbState()
 	}
-	avg := sum / len(stats.goroutineNumLRU)
 
-	// if current goroutine num reaches 126% of the previous average num
-	if float64(goroutineNum) > float64(avg)*1.25 {
-		fileName := fmt.Sprintf("%v.dump_%v", path.Join(dumpPath, "goroutine"), time.Now().Format("20060102150405"))
-		f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
-		if err != nil {
-			fmt.Println(err)
-			return false
-		}
-		defer f.Close()
+	// if current goroutine num is anomalous against the adaptively
+	// climbed threshold
+	anomalous := d.stats.goroutineClimb.check(d.adaptiveParams, d.stats.goroutineNumLRU, goroutineNum)
+	d.mu.Unlock()
+
+	if !anomalous {
+		return false
+	}
 
-		pprof.Lookup("goroutine").WriteTo(f, 1)
-		return true
+	d.captureTrace()
+
+	var buf bytes.Buffer
+	if prof := d.profiler.Lookup("goroutine"); prof != nil {
+		prof.WriteTo(&buf, 1)
+	}
+
+	name := d.dumpName("goroutine")
+	if err := d.sink.Write(context.Background(), "goroutine", name, &buf); err != nil {
+		d.logger.Println(err)
+		return false
 	}
 
-	return false
+	d.mu.Lock()
+	d.stats.latestGoroutineDumpTime = d.clock.Now()
+	d.mu.Unlock()
+	return true
+}
+
+// defaultDumper is what the package-level functions (Init, WithSink,
+// Handler, ...) operate on.
+var defaultDumper *Dumper
+
+func init() {
+	defaultDumper, _ = New(Config{})
+	defaultDumper.Start(context.Background())
+}
+
+// Init replaces the package-level default Dumper: set its dump path and
+// tick interval (in seconds), and apply any Options. The previous default
+// Dumper is stopped first.
+func Init(path string, interval int, maxDumpTimes int, opts ...Option) {
+	defaultDumper.Stop()
+
+	d, _ := New(Config{
+		Path:               path,
+		TickInterval:       time.Duration(interval) * time.Second,
+		MaxDumpTimesPerDay: maxDumpTimes,
+		Opts:               opts,
+	})
+	defaultDumper = d
+	defaultDumper.Start(context.Background())
 }
@@ -0,0 +1,453 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock lets a test control Now() directly instead of waiting on a
+// real timer; NewTicker is unused by the trigger-method tests below,
+// which drive memProfile/cpuProfile/goroutineProfile directly rather than
+// running the loop. afterCh backs After(), so a test can signal a
+// background goroutine (e.g. cpuProfile's completion wait) to proceed
+// without actually sleeping.
+type fakeClock struct {
+	now     time.Time
+	afterCh chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return &fakeTicker{ch: make(chan time.Time)}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.afterCh
+}
+
+type fakeTicker struct{ ch chan time.Time }
+
+func (t *fakeTicker) Chan() <-chan time.Time { return t.ch }
+func (t *fakeTicker) Stop()                  {}
+
+// fakeProfiler fabricates a fixed sequence of CPU/mem/goroutine readings;
+// each call advances to the next sample, holding on the last one once
+// exhausted.
+type fakeProfiler struct {
+	memPercents []float64
+	cpuPercents []float64
+	goroutines  []int
+	blockCounts []int
+	mutexCounts []int
+
+	memIdx, cpuIdx, gIdx, blockIdx, mutexIdx int
+}
+
+func (p *fakeProfiler) MemUsagePercent() (float64, error) {
+	v := p.memPercents[p.memIdx]
+	if p.memIdx < len(p.memPercents)-1 {
+		p.memIdx++
+	}
+	return v, nil
+}
+
+func (p *fakeProfiler) CPUUsagePercent() (float64, error) {
+	v := p.cpuPercents[p.cpuIdx]
+	if p.cpuIdx < len(p.cpuPercents)-1 {
+		p.cpuIdx++
+	}
+	return v, nil
+}
+
+func (p *fakeProfiler) NumGoroutine() int {
+	v := p.goroutines[p.gIdx]
+	if p.gIdx < len(p.goroutines)-1 {
+		p.gIdx++
+	}
+	return v
+}
+
+func (p *fakeProfiler) ReadMemStats() memStatsSnapshot            { return memStatsSnapshot{} }
+func (p *fakeProfiler) MemProfileStats() (memProfileStats, error) { return memProfileStats{}, nil }
+
+// Lookup returns a fakeProfileWriter reporting the next fabricated count
+// for "block"/"mutex"; any other name (e.g. "goroutine") gets a
+// zero-count writer since goroutineProfile gets its count from
+// NumGoroutine instead.
+func (p *fakeProfiler) Lookup(name string) ProfileWriter {
+	switch name {
+	case "block":
+		return fakeProfileWriter{count: p.next(&p.blockIdx, p.blockCounts)}
+	case "mutex":
+		return fakeProfileWriter{count: p.next(&p.mutexIdx, p.mutexCounts)}
+	default:
+		return fakeProfileWriter{}
+	}
+}
+
+func (p *fakeProfiler) next(idx *int, seq []int) int {
+	if len(seq) == 0 {
+		return 0
+	}
+	v := seq[*idx]
+	if *idx < len(seq)-1 {
+		*idx++
+	}
+	return v
+}
+
+func (p *fakeProfiler) StartCPUProfile(w io.Writer) error { return nil }
+func (p *fakeProfiler) StopCPUProfile()                   {}
+func (p *fakeProfiler) StartTrace(w io.Writer) error      { return nil }
+func (p *fakeProfiler) StopTrace()                        {}
+
+type fakeProfileWriter struct{ count int }
+
+func (w fakeProfileWriter) Count() int                          { return w.count }
+func (fakeProfileWriter) WriteTo(wr io.Writer, debug int) error { return nil }
+
+// fakeSink records what it was asked to write without touching disk.
+// written, if non-nil, is signaled after each Write so a test can wait
+// for a dump that completes on a background goroutine (e.g. the CPU
+// profile's delayed stop-and-write) instead of racing it.
+type fakeSink struct {
+	mu      sync.Mutex
+	writes  []string
+	written chan string
+}
+
+func (s *fakeSink) Write(ctx context.Context, kind, name string, r io.Reader) error {
+	s.mu.Lock()
+	s.writes = append(s.writes, kind)
+	s.mu.Unlock()
+	if s.written != nil {
+		s.written <- kind
+	}
+	return nil
+}
+
+func (s *fakeSink) writeCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.writes)
+}
+
+type fakeLogger struct{}
+
+func (fakeLogger) Println(v ...interface{}) {}
+
+func newTestDumper(clock *fakeClock, profiler *fakeProfiler, sink *fakeSink) *Dumper {
+	d, _ := New(Config{
+		Clock:    clock,
+		Profiler: profiler,
+		Sink:     sink,
+		Logger:   fakeLogger{},
+	})
+	return d
+}
+
+func TestDumperMemProfileFiresOnlyOnSpike(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{
+		memPercents: []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 100},
+	}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	want := []bool{false, false, false, false, false, false, false, false, false, false, true}
+	for i, w := range want {
+		got := d.memProfile()
+		if got != w {
+			t.Fatalf("sample %d: memProfile() = %v, want %v", i, got, w)
+		}
+		clock.now = clock.now.Add(31 * time.Second)
+	}
+	if len(sink.writes) != 1 || sink.writes[0] != "heap" {
+		t.Fatalf("sink.writes = %v, want exactly one heap dump", sink.writes)
+	}
+}
+
+func TestDumperMemProfileRespectsInterval(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{
+		memPercents: []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 100, 100},
+	}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	for i := 0; i < 11; i++ {
+		clock.now = clock.now.Add(31 * time.Second)
+		d.memProfile()
+	}
+
+	// immediately re-evaluate without letting memDumpInterval elapse
+	clock.now = clock.now.Add(1 * time.Second)
+	if d.memProfile() {
+		t.Fatalf("memProfile() fired again before memDumpInterval elapsed")
+	}
+}
+
+// TestDumperCPUProfileFiresOnlyOnSpike drives cpuProfile the way the dump
+// loop would, then signals the clock's After() channel to let the
+// background goroutine that stops the CPU profile and writes it out run,
+// and asserts the sink sees exactly one "cpu" write.
+func TestDumperCPUProfileFiresOnlyOnSpike(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0), afterCh: make(chan time.Time, 1)}
+	profiler := &fakeProfiler{
+		cpuPercents: []float64{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 100},
+	}
+	sink := &fakeSink{written: make(chan string, 1)}
+	d := newTestDumper(clock, profiler, sink)
+
+	want := []bool{false, false, false, false, false, false, false, false, false, false, true}
+	for i, w := range want {
+		got := d.cpuProfile()
+		if got != w {
+			t.Fatalf("sample %d: cpuProfile() = %v, want %v", i, got, w)
+		}
+		clock.now = clock.now.Add(31 * time.Second)
+	}
+
+	clock.afterCh <- clock.now.Add(cpuProfileDuration)
+
+	select {
+	case <-sink.written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the CPU profile to be stopped and written")
+	}
+
+	if n := sink.writeCount(); n != 1 || sink.writes[0] != "cpu" {
+		t.Fatalf("sink.writes = %v, want exactly one cpu dump", sink.writes)
+	}
+}
+
+func TestDumperGoroutineProfileFiresOnlyOnSpike(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{
+		goroutines: []int{20, 20, 20, 20, 20, 20, 20, 20, 20, 20, 400},
+	}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	want := []bool{false, false, false, false, false, false, false, false, false, false, true}
+	for i, w := range want {
+		got := d.goroutineProfile()
+		if got != w {
+			t.Fatalf("sample %d: goroutineProfile() = %v, want %v", i, got, w)
+		}
+		clock.now = clock.now.Add(31 * time.Second)
+	}
+}
+
+func TestDumperBlockProfileFiresOnlyOnSpike(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{
+		blockCounts: []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 100},
+	}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	want := []bool{false, false, false, false, false, false, false, false, false, false, true}
+	for i, w := range want {
+		got := d.blockProfile()
+		if got != w {
+			t.Fatalf("sample %d: blockProfile() = %v, want %v", i, got, w)
+		}
+		clock.now = clock.now.Add(31 * time.Second)
+	}
+	if len(sink.writes) != 1 || sink.writes[0] != "block" {
+		t.Fatalf("sink.writes = %v, want exactly one block dump", sink.writes)
+	}
+}
+
+func TestDumperMutexProfileFiresOnlyOnSpike(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{
+		mutexCounts: []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 100},
+	}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	want := []bool{false, false, false, false, false, false, false, false, false, false, true}
+	for i, w := range want {
+		got := d.mutexProfile()
+		if got != w {
+			t.Fatalf("sample %d: mutexProfile() = %v, want %v", i, got, w)
+		}
+		clock.now = clock.now.Add(31 * time.Second)
+	}
+	if len(sink.writes) != 1 || sink.writes[0] != "mutex" {
+		t.Fatalf("sink.writes = %v, want exactly one mutex dump", sink.writes)
+	}
+}
+
+// TestCaptureTraceWritesAfterDuration drives captureTrace directly and
+// signals the clock's After() channel to let its background
+// stop-and-write goroutine run, mirroring TestDumperCPUProfileFiresOnlyOnSpike.
+func TestCaptureTraceWritesAfterDuration(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0), afterCh: make(chan time.Time, 1)}
+	profiler := &fakeProfiler{}
+	sink := &fakeSink{written: make(chan string, 1)}
+	d := newTestDumper(clock, profiler, sink)
+
+	d.captureTrace()
+	clock.afterCh <- clock.now.Add(d.profilingCfg.traceDuration)
+
+	select {
+	case <-sink.written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the trace to be stopped and written")
+	}
+
+	if n := sink.writeCount(); n != 1 || sink.writes[0] != "trace" {
+		t.Fatalf("sink.writes = %v, want exactly one trace dump", sink.writes)
+	}
+}
+
+// TestGCPressureReasonRequiresTwoSamplesForStallCheck is regression
+// coverage for a NextGCStallCycles < 1 misconfiguration (e.g. the zero
+// value) that used to make trigger (b) evaluate a single-sample window,
+// where growing/stalled default to true and it fires on the very first
+// sample.
+func TestGCPressureReasonRequiresTwoSamplesForStallCheck(t *testing.T) {
+	params := GCPressureParams{NextGCStallCycles: 0, PauseBudget: time.Hour}
+	history := []memStatsSnapshot{{nextGC: 100, numGC: 1}}
+
+	if got := gcPressureReason(params, history, 0); got != "" {
+		t.Fatalf("gcPressureReason with NextGCStallCycles=0 and one sample = %q, want \"\"", got)
+	}
+}
+
+func TestMeanStdDev(t *testing.T) {
+	cases := []struct {
+		name       string
+		samples    []int
+		wantMean   float64
+		wantStddev float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", []int{10}, 10, 0},
+		{"constant", []int{5, 5, 5}, 5, 0},
+		{"varied", []int{2, 4, 4, 4, 5, 5, 7, 9}, 5, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mean, stddev := meanStdDev(c.samples)
+			if mean != c.wantMean || stddev != c.wantStddev {
+				t.Fatalf("meanStdDev(%v) = (%v, %v), want (%v, %v)", c.samples, mean, stddev, c.wantMean, c.wantStddev)
+			}
+		})
+	}
+}
+
+// TestClimbStateTracksRealAndFalseAlarms drives climbState.check over a
+// sequence of ticks the way the dump loop would (appending each sample to
+// its own rolling window before calling check), and asserts that ratio and
+// direction climb the right way for both outcomes: a false alarm should
+// raise the ratio (less sensitive next time), while a sustained real spike
+// should lower it (more sensitive) and suppress an immediate refire on the
+// same incident. Regression coverage for be2ec7e/3543180, which both had to
+// fix bugs in this exact state machine.
+func TestClimbStateTracksRealAndFalseAlarms(t *testing.T) {
+	params := defaultAdaptiveParams()
+
+	cases := []struct {
+		name       string
+		samples    []int // appended one at a time, each driving one check() call
+		wantFired  []bool
+		wantRatio  float64
+		wantUpward bool // direction > 0 (climbing up) vs < 0 (climbing down)
+	}{
+		{
+			name:       "false alarm raises the ratio",
+			samples:    append(repeat(10, 10), 100, 10, 10, 10),
+			wantFired:  append(repeatBool(false, 10), true, false, false, false),
+			wantRatio:  1.30,
+			wantUpward: true,
+		},
+		{
+			name:       "sustained spike lowers the ratio and suppresses the refire",
+			samples:    append(repeat(10, 10), 100, 100, 100, 100, 100, 100, 100, 100),
+			wantFired:  append(repeatBool(false, 10), true, false, true, false, false, false, false, false),
+			wantRatio:  1.15,
+			wantUpward: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := newClimbState()
+			var window []int
+
+			for i, sample := range c.samples {
+				window = append(window, sample)
+				window = trimToWindow(window, params.WindowSize)
+
+				fired := state.check(params, window, sample)
+				if fired != c.wantFired[i] {
+					t.Fatalf("tick %d: check(%v) = %v, want %v", i, sample, fired, c.wantFired[i])
+				}
+			}
+
+			if state.ratio != c.wantRatio {
+				t.Fatalf("ratio = %v, want %v", state.ratio, c.wantRatio)
+			}
+			if upward := state.direction > 0; upward != c.wantUpward {
+				t.Fatalf("direction = %v, want upward=%v", state.direction, c.wantUpward)
+			}
+		})
+	}
+}
+
+func repeat(v, n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func repeatBool(v bool, n int) []bool {
+	s := make([]bool, n)
+	for i := range s {
+		s[i] = v
+	}
+	return s
+}
+
+func TestDumperStopCancelsLoop(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	profiler := &fakeProfiler{memPercents: []float64{1}, cpuPercents: []float64{1}, goroutines: []int{1}}
+	sink := &fakeSink{}
+	d := newTestDumper(clock, profiler, sink)
+
+	d.Start(context.Background())
+	d.Stop()
+
+	select {
+	case <-d.done:
+	default:
+		t.Fatal("Stop returned before the loop goroutine exited")
+	}
+}
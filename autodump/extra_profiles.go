@@ -0,0 +1,203 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"time"
+)
+
+// profilingConfig holds the lazily-enabled block/mutex sampling state.
+// Both are off by default because they carry a perpetual sampling cost;
+// enable them via WithBlockProfiling/WithMutexProfiling. The sampling
+// rate itself (runtime.SetBlockProfileRate/SetMutexProfileFraction) is
+// process-global, so running two Dumpers with different rates in the
+// same process is not meaningful — same as it would be for any code
+// calling those runtime setters directly.
+type profilingConfig struct {
+	blockEnabled  bool
+	mutexEnabled  bool
+	traceDuration time.Duration
+}
+
+func defaultProfilingConfig() profilingConfig {
+	return profilingConfig{traceDuration: 5 * time.Second}
+}
+
+// WithBlockProfiling enables block-profile sampling at the given rate
+// (see runtime.SetBlockProfileRate) and turns on the block dump trigger
+// in the dump loop.
+func WithBlockProfiling(rate int) Option {
+	return func(d *Dumper) {
+		runtime.SetBlockProfileRate(rate)
+		d.profilingCfg.blockEnabled = true
+	}
+}
+
+// WithMutexProfiling enables mutex-profile sampling at the given fraction
+// (see runtime.SetMutexProfileFraction) and turns on the mutex dump
+// trigger in the dump loop.
+func WithMutexProfiling(fraction int) Option {
+	return func(d *Dumper) {
+		runtime.SetMutexProfileFraction(fraction)
+		d.profilingCfg.mutexEnabled = true
+	}
+}
+
+// WithTraceDuration overrides how long the execution trace captured
+// alongside a goroutine anomaly dump runs for. Defaults to 5s.
+func WithTraceDuration(dur time.Duration) Option {
+	return func(d *Dumper) {
+		d.profilingCfg.traceDuration = dur
+	}
+}
+
+// resetProfilingRates resets the block/mutex sampling rates to zero so a
+// process that embeds autodump doesn't keep paying the perpetual sampling
+// overhead after Stop.
+func (d *Dumper) resetProfilingRates() {
+	if d.profilingCfg.blockEnabled {
+		runtime.SetBlockProfileRate(0)
+		d.profilingCfg.blockEnabled = false
+	}
+	if d.profilingCfg.mutexEnabled {
+		runtime.SetMutexProfileFraction(0)
+		d.profilingCfg.mutexEnabled = false
+	}
+}
+
+// Shutdown resets defaultDumper's block/mutex sampling rates; see
+// (*Dumper).Stop, which does the same reset as part of a normal shutdown.
+func Shutdown() {
+	defaultDumper.resetProfilingRates()
+}
+
+func (d *Dumper) blockProfile() bool {
+	p := d.profiler.Lookup("block")
+	if p == nil {
+		return false
+	}
+	count := p.Count()
+
+	d.mu.Lock()
+	d.stats.blockCountLRU = append(d.stats.blockCountLRU, count)
+	d.stats.blockCountLRU = trimToWindow(d.stats.blockCountLRU, d.adaptiveParams.WindowSize)
+
+	if d.clock.Now().Sub(d.stats.latestBlockDumpTime) < d.threshold.blockDumpInterval {
+		d.mu.Unlock()
+		return false
+	}
+
+	if d.stats.blockClimb == nil {
+		d.stats.blockClimb = newClimbState()
+	}
+	anomalous := d.stats.blockClimb.check(d.adaptiveParams, d.stats.blockCountLRU, count)
+	d.mu.Unlock()
+	if !anomalous {
+		return false
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, 1); err != nil {
+		return false
+	}
+
+	name := d.dumpName("block")
+	if err := d.sink.Write(context.Background(), "block", name, &buf); err != nil {
+		d.logger.Println(err)
+		return false
+	}
+
+	d.mu.Lock()
+	d.stats.latestBlockDumpTime = d.clock.Now()
+	d.mu.Unlock()
+	return true
+}
+
+func (d *Dumper) mutexProfile() bool {
+	p := d.profiler.Lookup("mutex")
+	if p == nil {
+		return false
+	}
+	count := p.Count()
+
+	d.mu.Lock()
+	d.stats.mutexCountLRU = append(d.stats.mutexCountLRU, count)
+	d.stats.mutexCountLRU = trimToWindow(d.stats.mutexCountLRU, d.adaptiveParams.WindowSize)
+
+	if d.clock.Now().Sub(d.stats.latestMutexDumpTime) < d.threshold.mutexDumpInterval {
+		d.mu.Unlock()
+		return false
+	}
+
+	if d.stats.mutexClimb == nil {
+		d.stats.mutexClimb = newClimbState()
+	}
+	anomalous := d.stats.mutexClimb.check(d.adaptiveParams, d.stats.mutexCountLRU, count)
+	d.mu.Unlock()
+	if !anomalous {
+		return false
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, 1); err != nil {
+		return false
+	}
+
+	name := d.dumpName("mutex")
+	if err := d.sink.Write(context.Background(), "mutex", name, &buf); err != nil {
+		d.logger.Println(err)
+		return false
+	}
+
+	d.mu.Lock()
+	d.stats.latestMutexDumpTime = d.clock.Now()
+	d.mu.Unlock()
+	return true
+}
+
+// captureTrace records a runtime/trace execution trace alongside a
+// goroutine anomaly dump, so operators get a scheduler-level view of what
+// was happening when the goroutine count spiked. It is rate-limited by
+// threshold.traceDumpInterval like the other triggers.
+func (d *Dumper) captureTrace() {
+	d.mu.Lock()
+	if d.clock.Now().Sub(d.stats.latestTraceDumpTime) < d.threshold.traceDumpInterval {
+		d.mu.Unlock()
+		return
+	}
+	d.stats.latestTraceDumpTime = d.clock.Now()
+	d.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := d.profiler.StartTrace(&buf); err != nil {
+		d.logger.Println(err)
+		return
+	}
+
+	go func() {
+		<-d.clock.After(d.profilingCfg.traceDuration)
+		d.profiler.StopTrace()
+
+		name := d.dumpName("trace")
+		if err := d.sink.Write(context.Background(), "trace", name, &buf); err != nil {
+			d.logger.Println(err)
+		}
+	}()
+}
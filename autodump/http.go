@@ -0,0 +1,273 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxForceDumpDuration bounds the ?duration= query param on
+// /autodump/trigger so a client can't park a CPU profile (and the
+// handler goroutine driving it) open indefinitely.
+const maxForceDumpDuration = 60 * time.Second
+
+// Handler returns an http.Handler exposing defaultDumper's control
+// endpoints:
+//
+//	GET  /autodump/status        current stats and thresholds, as JSON
+//	POST /autodump/trigger       force an immediate dump, bypassing the daily cap (?duration= capped at maxForceDumpDuration for "cpu")
+//	PUT  /autodump/config        hot-update maxDumpTimesPerDay and the per-profile intervals
+//	GET  /autodump/pprof/*       proxy to the standard net/http/pprof profiles
+//
+// Mount it on an existing mux, or use ListenAndServe to run it on its own
+// port.
+func Handler() http.Handler { return defaultDumper.Handler() }
+
+// ListenAndServe starts an HTTP server on addr serving Handler(). It
+// blocks like http.ListenAndServe does.
+func ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, Handler())
+}
+
+// Handler returns an http.Handler exposing d's control endpoints; see the
+// package-level Handler for the endpoint list.
+func (d *Dumper) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/autodump/status", d.statusHandler)
+	mux.HandleFunc("/autodump/trigger", d.triggerHandler)
+	mux.HandleFunc("/autodump/config", d.configHandler)
+	mux.HandleFunc("/autodump/pprof/", pprofProxyHandler)
+	return mux
+}
+
+type statusResponse struct {
+	MaxDumpTimesPerDay int `json:"maxDumpTimesPerDay"`
+
+	CPUDumpIntervalSeconds       float64 `json:"cpuDumpIntervalSeconds"`
+	MemDumpIntervalSeconds       float64 `json:"memDumpIntervalSeconds"`
+	GoroutineDumpIntervalSeconds float64 `json:"goroutineDumpIntervalSeconds"`
+	BlockDumpIntervalSeconds     float64 `json:"blockDumpIntervalSeconds"`
+	MutexDumpIntervalSeconds     float64 `json:"mutexDumpIntervalSeconds"`
+	TraceDumpIntervalSeconds     float64 `json:"traceDumpIntervalSeconds"`
+
+	LatestCPUDumpTime       time.Time `json:"latestCPUDumpTime"`
+	LatestMemDumpTime       time.Time `json:"latestMemDumpTime"`
+	LatestGoroutineDumpTime time.Time `json:"latestGoroutineDumpTime"`
+	LatestBlockDumpTime     time.Time `json:"latestBlockDumpTime"`
+	LatestMutexDumpTime     time.Time `json:"latestMutexDumpTime"`
+	LatestTraceDumpTime     time.Time `json:"latestTraceDumpTime"`
+
+	CPUUsageLRU        []int `json:"cpuUsageLRU"`
+	MemUsageLRU        []int `json:"memUsageLRU"`
+	GoroutineNumLRU    []int `json:"goroutineNumLRU"`
+	BlockCountLRU      []int `json:"blockCountLRU"`
+	MutexCountLRU      []int `json:"mutexCountLRU"`
+	LatestGoroutineNum int   `json:"latestGoroutineNum"`
+
+	LatestMemDumpReason string `json:"latestMemDumpReason"`
+}
+
+func (d *Dumper) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d.mu.RLock()
+	resp := statusResponse{
+		MaxDumpTimesPerDay:           d.maxDumpTimesPerDay,
+		CPUDumpIntervalSeconds:       d.threshold.cpuDumpInterval.Seconds(),
+		MemDumpIntervalSeconds:       d.threshold.memDumpInterval.Seconds(),
+		GoroutineDumpIntervalSeconds: d.threshold.goroutineDumpInterval.Seconds(),
+		BlockDumpIntervalSeconds:     d.threshold.blockDumpInterval.Seconds(),
+		MutexDumpIntervalSeconds:     d.threshold.mutexDumpInterval.Seconds(),
+		TraceDumpIntervalSeconds:     d.threshold.traceDumpInterval.Seconds(),
+		LatestCPUDumpTime:            d.stats.latestCPUDumpTime,
+		LatestMemDumpTime:            d.stats.latestMemDumpTime,
+		LatestGoroutineDumpTime:      d.stats.latestGoroutineDumpTime,
+		LatestBlockDumpTime:          d.stats.latestBlockDumpTime,
+		LatestMutexDumpTime:          d.stats.latestMutexDumpTime,
+		LatestTraceDumpTime:          d.stats.latestTraceDumpTime,
+		CPUUsageLRU:                  append([]int(nil), d.stats.cpuUsageLRU...),
+		MemUsageLRU:                  append([]int(nil), d.stats.memUsageLRU...),
+		GoroutineNumLRU:              append([]int(nil), d.stats.goroutineNumLRU...),
+		BlockCountLRU:                append([]int(nil), d.stats.blockCountLRU...),
+		MutexCountLRU:                append([]int(nil), d.stats.mutexCountLRU...),
+		LatestGoroutineNum:           d.stats.latestGoroutineNum,
+		LatestMemDumpReason:          d.stats.latestMemDumpReason,
+	}
+	d.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// triggerHandler forces an immediate dump of the requested kind,
+// independent of the daily cap and the usual interval/anomaly gating.
+func (d *Dumper) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	duration := 30 * time.Second
+	if dur := r.URL.Query().Get("duration"); dur != "" {
+		parsed, err := time.ParseDuration(dur)
+		if err != nil {
+			http.Error(w, "invalid duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		duration = parsed
+	}
+	if duration <= 0 || duration > maxForceDumpDuration {
+		http.Error(w, fmt.Sprintf("duration must be > 0 and <= %s", maxForceDumpDuration), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.forceDump(r.Context(), kind, duration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// forceDump captures and writes a single dump of kind, sidestepping the
+// dump loop's daily cap and per-profile interval/anomaly gating entirely
+// — useful during an incident when you don't want to wait for the
+// automatic triggers.
+func (d *Dumper) forceDump(ctx context.Context, kind string, duration time.Duration) error {
+	name := d.dumpName(kind)
+
+	if kind == "cpu" {
+		// mutually exclusive with the loop's own cpuProfile, same as a
+		// periodic CPU dump would be.
+		if !atomic.CompareAndSwapInt64(&d.cpuDumpingFlag, 0, 1) {
+			return fmt.Errorf("autodump: a CPU profile is already in progress")
+		}
+		defer atomic.StoreInt64(&d.cpuDumpingFlag, 0)
+
+		var buf bytes.Buffer
+		if err := d.profiler.StartCPUProfile(&buf); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			d.profiler.StopCPUProfile()
+			return ctx.Err()
+		}
+
+		d.profiler.StopCPUProfile()
+		return d.sink.Write(ctx, kind, name, &buf)
+	}
+
+	profile := d.profiler.Lookup(kind)
+	if profile == nil {
+		return fmt.Errorf("autodump: unknown profile kind %q", kind)
+	}
+
+	var buf bytes.Buffer
+	if err := profile.WriteTo(&buf, 1); err != nil {
+		return err
+	}
+	return d.sink.Write(ctx, kind, name, &buf)
+}
+
+type configRequest struct {
+	MaxDumpTimesPerDay           *int `json:"maxDumpTimesPerDay"`
+	CPUDumpIntervalSeconds       *int `json:"cpuDumpIntervalSeconds"`
+	MemDumpIntervalSeconds       *int `json:"memDumpIntervalSeconds"`
+	GoroutineDumpIntervalSeconds *int `json:"goroutineDumpIntervalSeconds"`
+	BlockDumpIntervalSeconds     *int `json:"blockDumpIntervalSeconds"`
+	MutexDumpIntervalSeconds     *int `json:"mutexDumpIntervalSeconds"`
+	TraceDumpIntervalSeconds     *int `json:"traceDumpIntervalSeconds"`
+}
+
+// configHandler hot-updates maxDumpTimesPerDay and the per-profile
+// intervals; fields omitted from the request body are left unchanged.
+func (d *Dumper) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req configRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	if req.MaxDumpTimesPerDay != nil {
+		d.maxDumpTimesPerDay = *req.MaxDumpTimesPerDay
+	}
+	if req.CPUDumpIntervalSeconds != nil {
+		d.threshold.cpuDumpInterval = time.Duration(*req.CPUDumpIntervalSeconds) * time.Second
+	}
+	if req.MemDumpIntervalSeconds != nil {
+		d.threshold.memDumpInterval = time.Duration(*req.MemDumpIntervalSeconds) * time.Second
+	}
+	if req.GoroutineDumpIntervalSeconds != nil {
+		d.threshold.goroutineDumpInterval = time.Duration(*req.GoroutineDumpIntervalSeconds) * time.Second
+	}
+	if req.BlockDumpIntervalSeconds != nil {
+		d.threshold.blockDumpInterval = time.Duration(*req.BlockDumpIntervalSeconds) * time.Second
+	}
+	if req.MutexDumpIntervalSeconds != nil {
+		d.threshold.mutexDumpInterval = time.Duration(*req.MutexDumpIntervalSeconds) * time.Second
+	}
+	if req.TraceDumpIntervalSeconds != nil {
+		d.threshold.traceDumpInterval = time.Duration(*req.TraceDumpIntervalSeconds) * time.Second
+	}
+	d.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pprofProxyHandler proxies /autodump/pprof/* to the standard
+// net/http/pprof profiles, so operators don't need to open a second port
+// just for pprof.
+func pprofProxyHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/autodump/pprof/")
+	switch name {
+	case "", "index":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
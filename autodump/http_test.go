@@ -0,0 +1,253 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusHandlerReturnsJSON(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	d := newTestDumper(clock, &fakeProfiler{}, &fakeSink{})
+	d.maxDumpTimesPerDay = 7
+
+	req := httptest.NewRequest(http.MethodGet, "/autodump/status", nil)
+	rec := httptest.NewRecorder()
+	d.statusHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	var got statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got.MaxDumpTimesPerDay != 7 {
+		t.Fatalf("MaxDumpTimesPerDay = %d, want 7", got.MaxDumpTimesPerDay)
+	}
+	if got.CPUDumpIntervalSeconds != 30 {
+		t.Fatalf("CPUDumpIntervalSeconds = %v, want 30", got.CPUDumpIntervalSeconds)
+	}
+}
+
+func TestStatusHandlerRejectsNonGet(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	req := httptest.NewRequest(http.MethodPost, "/autodump/status", nil)
+	rec := httptest.NewRecorder()
+	d.statusHandler(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestTriggerHandlerForcesImmediateDump(t *testing.T) {
+	sink := &fakeSink{}
+	d := newTestDumper(&fakeClock{now: time.Unix(0, 0)}, &fakeProfiler{}, sink)
+
+	req := httptest.NewRequest(http.MethodPost, "/autodump/trigger?kind=goroutine", nil)
+	rec := httptest.NewRecorder()
+	d.triggerHandler(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202, body: %s", rec.Code, rec.Body.String())
+	}
+	if n := sink.writeCount(); n != 1 || sink.writes[0] != "goroutine" {
+		t.Fatalf("sink.writes = %v, want exactly one goroutine dump", sink.writes)
+	}
+}
+
+func TestTriggerHandlerRejectsNonPost(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	req := httptest.NewRequest(http.MethodGet, "/autodump/trigger?kind=goroutine", nil)
+	rec := httptest.NewRecorder()
+	d.triggerHandler(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestTriggerHandlerRejectsInvalidDuration(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+	}{
+		{"unparseable", "kind=goroutine&duration=not-a-duration"},
+		{"zero", "kind=goroutine&duration=0s"},
+		{"negative", "kind=goroutine&duration=-1s"},
+		{"too long", "kind=goroutine&duration=61s"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+			req := httptest.NewRequest(http.MethodPost, "/autodump/trigger?"+c.query, nil)
+			rec := httptest.NewRecorder()
+			d.triggerHandler(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestTriggerHandlerRejectsUnknownKind(t *testing.T) {
+	// fakeProfiler.Lookup always returns a zero-count writer for unknown
+	// names, so this needs the real profiler (whose Lookup wraps
+	// pprof.Lookup and returns nil for a name it doesn't recognize).
+	d, err := New(Config{Path: t.TempDir(), Logger: fakeLogger{}})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/autodump/trigger?kind=bogus", nil)
+	rec := httptest.NewRecorder()
+	d.triggerHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+// TestTriggerHandlerConcurrentSameKindDoesNotCollide is regression
+// coverage for the chunk0-5 naming bug: rapid-fire POST /autodump/trigger
+// calls for the same kind within the same second used to overwrite each
+// other on disk, silently dropping all but one dump. It fires many
+// concurrent requests against a real fsSink (not fakeSink, so the
+// filesystem collision the bug report described is actually exercised)
+// and asserts every request lands a distinct file.
+func TestTriggerHandlerConcurrentSameKindDoesNotCollide(t *testing.T) {
+	dir := t.TempDir()
+	// retention GC is orthogonal to the naming-collision fix under test;
+	// raise the per-kind cap so it doesn't reap any of the n dumps itself.
+	d, err := New(Config{Path: dir, Profiler: &fakeProfiler{}, Logger: fakeLogger{}, Opts: []Option{WithRetention(1000, 0)}})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	const n = 25
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := http.Post(srv.URL+"/autodump/trigger?kind=goroutine", "", nil)
+			if err != nil {
+				t.Errorf("request %d: %v", i, err)
+				return
+			}
+			defer resp.Body.Close()
+			codes[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusAccepted {
+			t.Fatalf("request %d status = %d, want 202", i, code)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != n {
+		t.Fatalf("len(entries) = %d, want %d distinct dump files, no collisions", len(entries), n)
+	}
+}
+
+func TestConfigHandlerUpdatesThresholds(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	body := strings.NewReader(`{"maxDumpTimesPerDay": 42, "cpuDumpIntervalSeconds": 60}`)
+	req := httptest.NewRequest(http.MethodPut, "/autodump/config", body)
+	rec := httptest.NewRecorder()
+	d.configHandler(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204, body: %s", rec.Code, rec.Body.String())
+	}
+	if d.maxDumpTimesPerDay != 42 {
+		t.Fatalf("maxDumpTimesPerDay = %d, want 42", d.maxDumpTimesPerDay)
+	}
+	if d.threshold.cpuDumpInterval != 60*time.Second {
+		t.Fatalf("cpuDumpInterval = %v, want 60s", d.threshold.cpuDumpInterval)
+	}
+	// untouched fields must keep their defaults.
+	if d.threshold.memDumpInterval != 30*time.Second {
+		t.Fatalf("memDumpInterval = %v, want unchanged 30s default", d.threshold.memDumpInterval)
+	}
+}
+
+func TestConfigHandlerRejectsNonPut(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	req := httptest.NewRequest(http.MethodGet, "/autodump/config", nil)
+	rec := httptest.NewRecorder()
+	d.configHandler(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestConfigHandlerRejectsInvalidBody(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	req := httptest.NewRequest(http.MethodPut, "/autodump/config", strings.NewReader(`not json`))
+	rec := httptest.NewRecorder()
+	d.configHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPprofProxyHandlerRoutesKnownProfiles(t *testing.T) {
+	d := newTestDumper(&fakeClock{}, &fakeProfiler{}, &fakeSink{})
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/autodump/pprof/cmdline")
+	if err != nil {
+		t.Fatalf("GET /autodump/pprof/cmdline: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+}
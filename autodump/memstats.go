@@ -0,0 +1,184 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/mem"
+)
+
+// memStatsSnapshot is a point-in-time sample of the runtime.MemStats
+// fields the GC-pressure trigger cares about.
+type memStatsSnapshot struct {
+	at           time.Time
+	heapInuse    uint64
+	nextGC       uint64
+	numGC        uint32
+	pauseTotalNs uint64
+}
+
+// GCPressureParams are the knobs for the GC-pressure memory trigger,
+// overridable via WithMemTrigger.
+type GCPressureParams struct {
+	HeapInuseLimitPercent float64       // trigger (a): HeapInuse vs limit
+	NextGCStallCycles     int           // trigger (b): NextGC growth window
+	PauseBudget           time.Duration // trigger (c): PauseTotalNs delta budget
+}
+
+func defaultGCPressureParams() GCPressureParams {
+	return GCPressureParams{
+		HeapInuseLimitPercent: 80,
+		NextGCStallCycles:     5,
+		PauseBudget:           200 * time.Millisecond,
+	}
+}
+
+// WithMemTrigger overrides the GC-pressure memory trigger's knobs:
+// heapInuseLimitPercent is the HeapInuse percentage (of the cgroup/process
+// memory limit) that fires trigger (a); nextGCStallCycles is how many
+// consecutive cycles NextGC must grow while NumGC stalls to fire trigger
+// (b) — it's clamped to at least 1, since trigger (b) needs at least two
+// samples to tell growing from flat; pauseBudget is the PauseTotalNs
+// delta over the window that fires trigger (c).
+func WithMemTrigger(heapInuseLimitPercent float64, nextGCStallCycles int, pauseBudget time.Duration) Option {
+	return func(d *Dumper) {
+		if nextGCStallCycles < 1 {
+			nextGCStallCycles = 1
+		}
+		d.gcPressureParams = GCPressureParams{
+			HeapInuseLimitPercent: heapInuseLimitPercent,
+			NextGCStallCycles:     nextGCStallCycles,
+			PauseBudget:           pauseBudget,
+		}
+	}
+}
+
+// recordMemStatsSnapshot appends s to d.stats.memStatsHistory, keeping
+// only the last NextGCStallCycles+1 samples — enough to evaluate the
+// stalled-GC trigger.
+func (d *Dumper) recordMemStatsSnapshot(s memStatsSnapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stats.memStatsHistory = append(d.stats.memStatsHistory, s)
+	maxLen := d.gcPressureParams.NextGCStallCycles + 1
+	if len(d.stats.memStatsHistory) > maxLen {
+		d.stats.memStatsHistory = d.stats.memStatsHistory[len(d.stats.memStatsHistory)-maxLen:]
+	}
+}
+
+// MemStatsHistory returns the rolling window of MemStats snapshots
+// autodump has sampled, oldest first, so callers can see exactly why (or
+// why not) a memory dump fired.
+func (d *Dumper) MemStatsHistory() []runtime.MemStats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	out := make([]runtime.MemStats, 0, len(d.stats.memStatsHistory))
+	for _, s := range d.stats.memStatsHistory {
+		var m runtime.MemStats
+		m.HeapInuse = s.heapInuse
+		m.NextGC = s.nextGC
+		m.NumGC = s.numGC
+		m.PauseTotalNs = s.pauseTotalNs
+		out = append(out, m)
+	}
+	return out
+}
+
+// MemStatsHistory returns defaultDumper.MemStatsHistory(); see the method
+// docs for details.
+func MemStatsHistory() []runtime.MemStats { return defaultDumper.MemStatsHistory() }
+
+// LastMemDumpReason returns which trigger caused the most recent heap
+// dump: "rss_anomaly" for the hill-climbing RSS% detector, or one of
+// "heap_inuse_exceeds_limit", "gc_not_keeping_up", or
+// "gc_pause_budget_exceeded" for the GC-pressure triggers. It is empty
+// until the first heap dump fires.
+func (d *Dumper) LastMemDumpReason() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.stats.latestMemDumpReason
+}
+
+// LastMemDumpReason returns defaultDumper.LastMemDumpReason(); see the
+// method docs for details.
+func LastMemDumpReason() string { return defaultDumper.LastMemDumpReason() }
+
+// memLimitBytes returns the denominator trigger (a) measures HeapInuse
+// against: the cgroup memory limit when running in a container, or total
+// host memory otherwise.
+func memLimitBytes() (uint64, error) {
+	if limit, ok := GetCgroupMemoryLimit(); ok {
+		return limit, nil
+	}
+
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return 0, err
+	}
+	return vm.Total, nil
+}
+
+// gcPressureReason evaluates the three GC-pressure triggers against the
+// rolling history (latest sample last) and returns a human-readable
+// reason when one fires, or "" when none do.
+func gcPressureReason(params GCPressureParams, history []memStatsSnapshot, limitBytes uint64) string {
+	if len(history) == 0 {
+		return ""
+	}
+	latest := history[len(history)-1]
+
+	// (a) HeapInuse exceeds X% of the cgroup/process memory limit.
+	if limitBytes > 0 {
+		percent := float64(latest.heapInuse) / float64(limitBytes) * 100
+		if percent >= params.HeapInuseLimitPercent {
+			return "heap_inuse_exceeds_limit"
+		}
+	}
+
+	// (b) NextGC growing monotonically for N cycles while NumGC stalls.
+	// Needs at least two samples to tell growing from flat, so a
+	// misconfigured NextGCStallCycles < 1 can't make this fire on a
+	// single sample.
+	if stallCycles := params.NextGCStallCycles; stallCycles >= 1 && len(history) > stallCycles {
+		window := history[len(history)-stallCycles-1:]
+		growing, stalled := true, true
+		for i := 1; i < len(window); i++ {
+			if window[i].nextGC <= window[i-1].nextGC {
+				growing = false
+			}
+			if window[i].numGC != window[0].numGC {
+				stalled = false
+			}
+		}
+		if growing && stalled {
+			return "gc_not_keeping_up"
+		}
+	}
+
+	// (c) PauseTotalNs delta over the window exceeds the configured budget.
+	oldest := history[0]
+	pauseDelta := time.Duration(latest.pauseTotalNs - oldest.pauseTotalNs)
+	if pauseDelta > params.PauseBudget {
+		return "gc_pause_budget_exceeded"
+	}
+
+	return ""
+}
@@ -0,0 +1,205 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// memProfileStats summarizes runtime.MemProfile into the few totals
+// autodump logs alongside a heap dump.
+type memProfileStats struct {
+	totalAllocSize, totalAllocObject int
+	totalFreedSize, totalFreedObject int
+	totalInuseSize, totalInuseObject int
+}
+
+// ProfileWriter is the subset of *pprof.Profile that Profiler.Lookup
+// needs; *pprof.Profile satisfies it directly.
+type ProfileWriter interface {
+	Count() int
+	WriteTo(w io.Writer, debug int) error
+}
+
+// Profiler abstracts the runtime/process introspection autodump's
+// triggers depend on, so tests can fabricate CPU/mem/goroutine sequences
+// and assert exactly when a trigger fires, without touching real
+// process stats or disk.
+type Profiler interface {
+	// MemUsagePercent returns RSS as a percentage of the cgroup/host
+	// memory limit.
+	MemUsagePercent() (float64, error)
+	// CPUUsagePercent returns CPU usage as a percentage of the
+	// cgroup/host CPU quota.
+	CPUUsagePercent() (float64, error)
+	NumGoroutine() int
+	ReadMemStats() memStatsSnapshot
+	MemProfileStats() (memProfileStats, error)
+
+	// Lookup returns the named predefined profile (heap, goroutine,
+	// block, mutex), mirroring pprof.Lookup.
+	Lookup(name string) ProfileWriter
+
+	StartCPUProfile(w io.Writer) error
+	StopCPUProfile()
+
+	StartTrace(w io.Writer) error
+	StopTrace()
+}
+
+// realProfiler is the default Profiler, backed by the runtime, gopsutil
+// and this process's cgroup.
+type realProfiler struct {
+	cpuSampler cgroupCPUSampler
+}
+
+func newRealProfiler() *realProfiler {
+	return &realProfiler{}
+}
+
+// = rss / max memory
+//
+// When the process is confined by a cgroup, "max memory" is the cgroup's
+// memory limit rather than total host memory, so RSS% reflects how close
+// the container is to being OOM-killed instead of how full the host is.
+func (p *realProfiler) MemUsagePercent() (float64, error) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return 0, err
+	}
+
+	if limit, ok := GetCgroupMemoryLimit(); ok {
+		rss, err := proc.MemoryInfo()
+		if err != nil {
+			return 0, err
+		}
+		return float64(rss.RSS) / float64(limit) * 100, nil
+	}
+
+	percent, err := proc.MemoryPercent()
+	return float64(percent), err
+}
+
+// CPUUsagePercent returns the process CPU usage as a percentage. When the
+// process is running inside a cgroup, the percentage is relative to the
+// cgroup's CPU quota (container-relative); otherwise it falls back to
+// gopsutil's host-relative percentage.
+func (p *realProfiler) CPUUsagePercent() (float64, error) {
+	if IsCgroup() {
+		if percent, ok := p.cpuSampler.sample(timeNowUnixNano()); ok {
+			return percent, nil
+		}
+		// cgroup files were unreadable this tick, fall back to gopsutil below
+	}
+
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return 0, err
+	}
+
+	return proc.Percent(0)
+}
+
+func (p *realProfiler) NumGoroutine() int {
+	return runtime.NumGoroutine()
+}
+
+func (p *realProfiler) ReadMemStats() memStatsSnapshot {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return memStatsSnapshot{
+		at:           timeNow(),
+		heapInuse:    m.HeapInuse,
+		nextGC:       m.NextGC,
+		numGC:        m.NumGC,
+		pauseTotalNs: m.PauseTotalNs,
+	}
+}
+
+func (p *realProfiler) MemProfileStats() (memProfileStats, error) {
+	var (
+		totalAllocSize, totalAllocObject = 0, 0
+		totalFreedSize, totalFreedObject = 0, 0
+		totalInuseSize, totalInuseObject = 0, 0
+	)
+
+	profiles := make([]runtime.MemProfileRecord, 2)
+	n, ok := runtime.MemProfile(profiles, false)
+	if ok {
+		profiles = profiles[0:n]
+	} else {
+		// TODO
+		return memProfileStats{}, nil
+	}
+
+	for _, profile := range profiles {
+		totalAllocSize += int(profile.AllocBytes)
+		totalFreedSize += int(profile.FreeBytes)
+		totalInuseSize += int(profile.InUseBytes())
+
+		totalAllocObject += int(profile.AllocObjects)
+		totalFreedObject += int(profile.FreeObjects)
+		totalInuseObject += int(profile.InUseObjects())
+	}
+
+	return memProfileStats{
+		totalAllocSize:   totalAllocSize,
+		totalAllocObject: totalAllocObject,
+		totalFreedSize:   totalFreedSize,
+		totalFreedObject: totalFreedObject,
+		totalInuseSize:   totalInuseSize,
+		totalInuseObject: totalInuseObject,
+	}, nil
+}
+
+func (p *realProfiler) Lookup(name string) ProfileWriter {
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		return nil
+	}
+	return prof
+}
+
+func (p *realProfiler) StartCPUProfile(w io.Writer) error {
+	return pprof.StartCPUProfile(w)
+}
+
+func (p *realProfiler) StopCPUProfile() {
+	pprof.StopCPUProfile()
+}
+
+func (p *realProfiler) StartTrace(w io.Writer) error {
+	return trace.Start(w)
+}
+
+func (p *realProfiler) StopTrace() {
+	trace.Stop()
+}
+
+// timeNow/timeNowUnixNano exist so the cgroup CPU sampler (which takes a
+// wall-clock reading independent of the injected Clock) stays testable
+// without pulling time.Now into every call site directly.
+func timeNow() time.Time { return time.Now() }
+
+func timeNowUnixNano() int64 { return time.Now().UnixNano() }
@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DumpSink abstracts where profile dumps are written, so a dump can be
+// streamed to local disk, object storage, or anywhere else that accepts a
+// byte stream, without the trigger code caring which.
+type DumpSink interface {
+	// Write stores the bytes read from r as a dump of the given kind
+	// (e.g. "heap", "cpu", "goroutine", "block", "mutex", "trace") under
+	// name. Implementations should treat r as a single pass stream.
+	Write(ctx context.Context, kind, name string, r io.Reader) error
+}
+
+// WithSink overrides the default filesystem sink that profile dumps are
+// streamed to, e.g. with one of NewS3Sink, NewOSSSink, NewOBSSink or
+// NewQiniuSink.
+func WithSink(s DumpSink) Option {
+	return func(d *Dumper) {
+		d.sink = s
+	}
+}
+
+// Retention bounds how many dump files the filesystem sink keeps, so a
+// long-lived container doesn't fill local disk between restarts.
+type Retention struct {
+	MaxPerKindPerDay int
+	MaxTotalBytes    int64 // 0 disables size-based GC
+}
+
+func defaultRetention() Retention {
+	return Retention{MaxPerKindPerDay: 20}
+}
+
+// WithRetention configures the filesystem sink's retention policy:
+// maxPerKindPerDay caps how many dumps of a given kind (heap, cpu, ...)
+// are kept per calendar day, and maxTotalBytes, if non-zero, triggers
+// size-based GC of the oldest dumps once the directory exceeds it.
+func WithRetention(maxPerKindPerDay int, maxTotalBytes int64) Option {
+	return func(d *Dumper) {
+		d.retention = Retention{MaxPerKindPerDay: maxPerKindPerDay, MaxTotalBytes: maxTotalBytes}
+	}
+}
+
+// fsSink is the default DumpSink: it gzips each dump and writes it under
+// d.dumpPath, then enforces d's retention policy.
+type fsSink struct {
+	d *Dumper
+}
+
+func newFSSink(d *Dumper) *fsSink {
+	return &fsSink{d: d}
+}
+
+func (s *fsSink) Write(ctx context.Context, kind, name string, r io.Reader) error {
+	dumpPath := s.d.dumpPath
+	if err := os.MkdirAll(dumpPath, 0755); err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(dumpPath, name+".gz")
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	if _, err := io.Copy(gw, r); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return enforceRetention(dumpPath, s.d.retention, kind)
+}
+
+// enforceRetention removes the oldest dumps of kind beyond
+// retention.MaxPerKindPerDay for the current day, then — if
+// retention.MaxTotalBytes is set — removes the oldest dumps of any kind
+// until the directory is back under budget.
+func enforceRetention(dumpPath string, retention Retention, kind string) error {
+	entries, err := os.ReadDir(dumpPath)
+	if err != nil {
+		return err
+	}
+	sortByModTime(entries)
+
+	today := time.Now().Format("20060102")
+	var kindToday []os.DirEntry
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), kind+".") && strings.Contains(e.Name(), today) {
+			kindToday = append(kindToday, e)
+		}
+	}
+
+	for len(kindToday) > retention.MaxPerKindPerDay {
+		if err := os.Remove(filepath.Join(dumpPath, kindToday[0].Name())); err != nil {
+			return err
+		}
+		kindToday = kindToday[1:]
+	}
+
+	if retention.MaxTotalBytes <= 0 {
+		return nil
+	}
+
+	files, total := dirFiles(entries)
+	for total > retention.MaxTotalBytes && len(files) > 0 {
+		oldest := files[0]
+		files = files[1:]
+		info, err := oldest.Info()
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dumpPath, oldest.Name())); err != nil {
+			return err
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
+
+func dirFiles(entries []os.DirEntry) (files []os.DirEntry, totalBytes int64) {
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, e)
+		totalBytes += info.Size()
+	}
+	return files, totalBytes
+}
+
+func sortByModTime(entries []os.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		ii, erri := entries[i].Info()
+		jj, errj := entries[j].Info()
+		if erri != nil || errj != nil {
+			return false
+		}
+		return ii.ModTime().Before(jj.ModTime())
+	})
+}
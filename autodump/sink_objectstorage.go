@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ObjectStorageConfig points a DumpSink at an S3-compatible bucket. Aliyun
+// OSS and Huawei OBS both speak this same API shape under their
+// S3-compatibility modes, so NewOSSSink/NewOBSSink return the same sink
+// as NewS3Sink, using cfg as given.
+type ObjectStorageConfig struct {
+	Endpoint  string // e.g. "https://s3.us-east-1.amazonaws.com"
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every object key, e.g. "autodump/my-service/".
+	Prefix string
+}
+
+// objectStorageSink uploads dumps to an S3-compatible bucket via a plain
+// SigV4-signed PUT, gzip-compressed on the wire.
+type objectStorageSink struct {
+	cfg ObjectStorageConfig
+}
+
+// NewS3Sink returns a DumpSink that uploads dumps to an AWS S3 bucket.
+func NewS3Sink(cfg ObjectStorageConfig) DumpSink {
+	return &objectStorageSink{cfg: cfg}
+}
+
+// NewOSSSink returns a DumpSink that uploads dumps to an Aliyun OSS
+// bucket via its S3-compatible endpoint.
+func NewOSSSink(cfg ObjectStorageConfig) DumpSink {
+	return &objectStorageSink{cfg: cfg}
+}
+
+// NewOBSSink returns a DumpSink that uploads dumps to a Huawei Cloud OBS
+// bucket via its S3-compatible endpoint.
+func NewOBSSink(cfg ObjectStorageConfig) DumpSink {
+	return &objectStorageSink{cfg: cfg}
+}
+
+func (s *objectStorageSink) Write(ctx context.Context, kind, name string, r io.Reader) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gw, r); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	body := buf.Bytes()
+
+	key := s.cfg.Prefix + kind + "/" + name + ".gz"
+	url := fmt.Sprintf("%s/%s/%s", strings.TrimRight(s.cfg.Endpoint, "/"), s.cfg.Bucket, key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+	signAWSv4(req, body, s.cfg.Region, s.cfg.AccessKey, s.cfg.SecretKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("autodump: object storage upload failed with status %v", resp.Status)
+	}
+	return nil
+}
+
+// signAWSv4 applies a minimal AWS Signature Version 4 signature,
+// sufficient for S3-compatible PUT Object requests against AWS, Aliyun
+// OSS and Huawei OBS.
+func signAWSv4(req *http.Request, body []byte, region, accessKey, secretKey string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+
+	credentialScope := strings.Join([]string{dateStamp, region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+secretKey), dateStamp), region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		accessKey, credentialScope, signature))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,124 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// QiniuConfig authenticates against the Qiniu Kodo object storage API,
+// which uses upload tokens rather than the S3-style request signing
+// NewS3Sink/NewOSSSink/NewOBSSink rely on.
+type QiniuConfig struct {
+	UploadEndpoint string // e.g. "https://upload.qiniup.com"
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	// Prefix is prepended to every object key, e.g. "autodump/my-service/".
+	Prefix string
+}
+
+type qiniuSink struct {
+	cfg QiniuConfig
+}
+
+// NewQiniuSink returns a DumpSink that uploads dumps to a Qiniu Kodo
+// bucket using its token-based form upload API.
+func NewQiniuSink(cfg QiniuConfig) DumpSink {
+	return &qiniuSink{cfg: cfg}
+}
+
+func (s *qiniuSink) Write(ctx context.Context, kind, name string, r io.Reader) error {
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	if _, err := io.Copy(gw, r); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	key := s.cfg.Prefix + kind + "/" + name + ".gz"
+	token := s.uploadToken(key)
+
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	if err := w.WriteField("token", token); err != nil {
+		return err
+	}
+	if err := w.WriteField("key", key); err != nil {
+		return err
+	}
+	part, err := w.CreateFormFile("file", name+".gz")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, &gzBuf); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.UploadEndpoint, &form)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("autodump: qiniu upload failed with status %v", resp.Status)
+	}
+	return nil
+}
+
+// uploadToken builds a Qiniu "simple" upload token: AccessKey, the
+// URL-safe base64 HMAC-SHA1 signature of the encoded put policy, and the
+// URL-safe base64 JSON put policy itself, joined by colons.
+func (s *qiniuSink) uploadToken(key string) string {
+	deadline := time.Now().Add(time.Hour).Unix()
+	policy, _ := json.Marshal(map[string]interface{}{
+		"scope":    s.cfg.Bucket + ":" + key,
+		"deadline": deadline,
+	})
+
+	encodedPolicy := base64.URLEncoding.EncodeToString(policy)
+
+	mac := hmac.New(sha1.New, []byte(s.cfg.SecretKey))
+	mac.Write([]byte(encodedPolicy))
+	sign := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("%s:%s:%s", s.cfg.AccessKey, sign, encodedPolicy)
+}
@@ -0,0 +1,257 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package autodump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSSinkWriteGzipsContent(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := New(Config{Path: dir})
+
+	if err := d.sink.Write(context.Background(), "heap", "heap.dump_20260101000000.1", strings.NewReader("profile bytes")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "heap.dump_20260101000000.1.gz"))
+	if err != nil {
+		t.Fatalf("expected dump file on disk: %v", err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzip content: %v", err)
+	}
+	if string(got) != "profile bytes" {
+		t.Fatalf("dump content = %q, want %q", got, "profile bytes")
+	}
+}
+
+func writeFakeDump(t *testing.T, dir, name string, size int) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+		t.Fatalf("writing fixture %s: %v", name, err)
+	}
+}
+
+func TestEnforceRetentionMaxPerKindPerDay(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().Format("20060102")
+
+	for i := 0; i < 5; i++ {
+		writeFakeDump(t, dir, "heap.dump_"+today+"00000"+string(rune('0'+i))+".gz", 1)
+		time.Sleep(time.Millisecond) // keep ModTime strictly increasing so oldest-first GC is deterministic
+	}
+
+	if err := enforceRetention(dir, Retention{MaxPerKindPerDay: 2}, "heap"); err != nil {
+		t.Fatalf("enforceRetention() = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2 after retention GC", len(entries))
+	}
+	// the two most recently written dumps (...03, ...04) should survive.
+	for _, e := range entries {
+		if !strings.Contains(e.Name(), "000003") && !strings.Contains(e.Name(), "000004") {
+			t.Fatalf("unexpected surviving file %s, want only the two most recent dumps", e.Name())
+		}
+	}
+}
+
+func TestEnforceRetentionMaxTotalBytes(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().Format("20060102")
+
+	writeFakeDump(t, dir, "heap.dump_"+today+"000000.gz", 100)
+	time.Sleep(time.Millisecond)
+	writeFakeDump(t, dir, "heap.dump_"+today+"000001.gz", 100)
+
+	if err := enforceRetention(dir, Retention{MaxPerKindPerDay: 100, MaxTotalBytes: 150}, "heap"); err != nil {
+		t.Fatalf("enforceRetention() = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1 after size-based GC", len(entries))
+	}
+	if !strings.Contains(entries[0].Name(), "000001") {
+		t.Fatalf("surviving file = %s, want the more recently written dump", entries[0].Name())
+	}
+}
+
+func TestObjectStorageSinkWrite(t *testing.T) {
+	var gotMethod, gotPath, gotAuth string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = body
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewS3Sink(ObjectStorageConfig{
+		Endpoint:  srv.URL,
+		Bucket:    "my-bucket",
+		Region:    "us-east-1",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+		Prefix:    "autodump/",
+	})
+
+	if err := sink.Write(context.Background(), "heap", "heap.dump_20260101000000.1", strings.NewReader("profile bytes")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	if gotMethod != http.MethodPut {
+		t.Fatalf("method = %q, want PUT", gotMethod)
+	}
+	wantPath := "/my-bucket/autodump/heap/heap.dump_20260101000000.1.gz"
+	if gotPath != wantPath {
+		t.Fatalf("path = %q, want %q", gotPath, wantPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Fatalf("Authorization = %q, want an AWS4-HMAC-SHA256 credential for AKIDEXAMPLE", gotAuth)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(gotBody))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	if string(got) != "profile bytes" {
+		t.Fatalf("uploaded content = %q, want %q", got, "profile bytes")
+	}
+}
+
+func TestObjectStorageSinkWriteReturnsErrorOnFailureStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := NewOSSSink(ObjectStorageConfig{Endpoint: srv.URL, Bucket: "b", Region: "r", AccessKey: "a", SecretKey: "s"})
+	if err := sink.Write(context.Background(), "heap", "heap.dump_1", strings.NewReader("x")); err == nil {
+		t.Fatal("Write() = nil error, want an error on a 500 response")
+	}
+}
+
+func TestQiniuSinkWriteUploadsMultipartForm(t *testing.T) {
+	var gotToken, gotKey string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("parsing Content-Type: %v", err)
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading multipart part: %v", err)
+			}
+			data, _ := io.ReadAll(part)
+			switch part.FormName() {
+			case "token":
+				gotToken = string(data)
+			case "key":
+				gotKey = string(data)
+			case "file":
+				gotBody = data
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewQiniuSink(QiniuConfig{
+		UploadEndpoint: srv.URL,
+		Bucket:         "my-bucket",
+		AccessKey:      "ak",
+		SecretKey:      "sk",
+		Prefix:         "autodump/",
+	})
+
+	if err := sink.Write(context.Background(), "cpu", "cpu.dump_20260101000000.1", strings.NewReader("profile bytes")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	wantKey := "autodump/cpu/cpu.dump_20260101000000.1.gz"
+	if gotKey != wantKey {
+		t.Fatalf("key = %q, want %q", gotKey, wantKey)
+	}
+	if !strings.HasPrefix(gotToken, "ak:") {
+		t.Fatalf("token = %q, want it to start with the access key", gotToken)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(gotBody))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzip content: %v", err)
+	}
+	if string(got) != "profile bytes" {
+		t.Fatalf("uploaded content = %q, want %q", got, "profile bytes")
+	}
+}
+
+func TestQiniuSinkWriteReturnsErrorOnFailureStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	sink := NewQiniuSink(QiniuConfig{UploadEndpoint: srv.URL, Bucket: "b", AccessKey: "a", SecretKey: "s"})
+	if err := sink.Write(context.Background(), "heap", "heap.dump_1", strings.NewReader("x")); err == nil {
+		t.Fatal("Write() = nil error, want an error on a 403 response")
+	}
+}